@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressAuto = "auto"
+	compressGzip = "gzip"
+	compressBr   = "br"
+	compressZstd = "zstd"
+	compressOff  = "off"
+)
+
+// acceptedEncodings parses an Accept-Encoding header into the set of
+// content codings the client will take. A coding explicitly disabled with
+// a zero quality value (e.g. "gzip;q=0") is left out.
+func acceptedEncodings(header string) map[string]bool {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.SplitN(part, ";", 2)
+		coding := strings.TrimSpace(fields[0])
+		if coding == "" {
+			continue
+		}
+		if len(fields) == 2 {
+			if quality, ok := parseQuality(fields[1]); ok && quality == 0 {
+				continue
+			}
+		}
+		accepted[coding] = true
+	}
+	return accepted
+}
+
+// parseQuality extracts the "q" parameter from the parameters following a
+// coding in an Accept-Encoding header, e.g. ";q=0.8". The second return
+// value is false if no q parameter is present or it can't be parsed.
+func parseQuality(params string) (float64, bool) {
+	for _, param := range strings.Split(params, ";") {
+		name, value, found := strings.Cut(param, "=")
+		if !found || strings.TrimSpace(name) != "q" {
+			continue
+		}
+		quality, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			continue
+		}
+		return quality, true
+	}
+	return 0, false
+}
+
+// negotiateEncoding picks the content coding to use for a response given
+// what the client accepts and the configured --compress mode. It returns
+// "" when nothing should be compressed.
+func negotiateEncoding(acceptEncoding string, mode string) string {
+	if mode == compressOff {
+		return ""
+	}
+
+	accepted := acceptedEncodings(acceptEncoding)
+	if accepted["identity"] && len(accepted) == 1 {
+		return ""
+	}
+
+	if mode != compressAuto {
+		if accepted[mode] {
+			return mode
+		}
+		return ""
+	}
+
+	for _, candidate := range []string{compressBr, compressZstd, compressGzip} {
+		if accepted[candidate] {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// isIncompressible reports whether a MIME type is already compressed, so
+// recompressing it would waste CPU for no benefit.
+func isIncompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"):
+		return true
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip",
+		"application/x-bzip2", "application/x-7z-compressed",
+		"application/x-rar-compressed", "application/wasm", "font/woff2":
+		return true
+	}
+	return false
+}
+
+// compressWriter wraps an http.ResponseWriter and transparently compresses
+// the body once enough bytes are written to clear compressMinSize. Small
+// responses and already-compressed content types are left untouched.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   string
+	minSize    int
+	buf        bytes.Buffer
+	writer     io.WriteCloser
+	decided    bool
+	compress   bool
+	statusCode int
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.statusCode == 0 {
+		w.statusCode = status
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.compress {
+			return w.writer.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf.Write(p)
+	if w.buf.Len() < w.minSize {
+		return len(p), nil
+	}
+
+	if err := w.decide(); err != nil {
+		return 0, err
+	}
+	return len(p), w.flushBuffered()
+}
+
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			return err
+		}
+		if err := w.flushBuffered(); err != nil {
+			return err
+		}
+	}
+	if w.compress {
+		return w.writer.Close()
+	}
+	return nil
+}
+
+func (w *compressWriter) decide() error {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+
+	header := w.ResponseWriter.Header()
+	alreadyEncoded := header.Get("Content-Encoding") != ""
+	partial := w.statusCode == http.StatusPartialContent
+	w.compress = !alreadyEncoded && !partial && !isIncompressible(header.Get("Content-Type")) && w.buf.Len() >= w.minSize
+
+	header.Add("Vary", "Accept-Encoding")
+	if w.compress {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+
+		switch w.encoding {
+		case compressGzip:
+			w.writer = gzip.NewWriter(w.ResponseWriter)
+		case compressBr:
+			w.writer = brotli.NewWriter(w.ResponseWriter)
+		case compressZstd:
+			zw, err := zstd.NewWriter(w.ResponseWriter)
+			if err != nil {
+				return fmt.Errorf("trouble creating zstd writer: %w", err)
+			}
+			w.writer = zw
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	w.decided = true
+	return nil
+}
+
+func (w *compressWriter) flushBuffered() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	if w.compress {
+		_, err := w.writer.Write(w.buf.Bytes())
+		return err
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	return err
+}
+
+// withCompression negotiates Accept-Encoding and streams the response body
+// through a gzip, brotli, or zstd writer, skipping incompressible content
+// types and responses smaller than minSize.
+func withCompression(mode string, minSize int, handler http.Handler) http.Handler {
+	if mode == compressOff {
+		return handler
+	}
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		// a Range request asks for a byte slice of the original,
+		// uncompressed representation; compressing it would produce a
+		// fragment of a separate compressed stream that the requested
+		// Content-Range offsets don't describe and that can't be
+		// reassembled with other ranges, so leave it alone entirely.
+		if request.Header.Get("Range") != "" {
+			handler.ServeHTTP(response, request)
+			return
+		}
+
+		encoding := negotiateEncoding(request.Header.Get("Accept-Encoding"), mode)
+		if encoding == "" {
+			response.Header().Add("Vary", "Accept-Encoding")
+			handler.ServeHTTP(response, request)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: response, encoding: encoding, minSize: minSize}
+		handler.ServeHTTP(cw, request)
+		if err := cw.Close(); err != nil {
+			fmt.Printf("trouble closing compressed response: %s\n", err)
+		}
+	})
+}
+
+type precompressedVariant struct {
+	ext      string
+	encoding string
+}
+
+var precompressedVariants = []precompressedVariant{
+	{ext: ".br", encoding: compressBr},
+	{ext: ".gz", encoding: compressGzip},
+}
+
+// withPrecompressed serves a foo.js.br or foo.js.gz sidecar straight from
+// the store when the client accepts that encoding, the way static site
+// hosts do, instead of compressing foo.js on every request.
+func withPrecompressed(store Store, mode string, handler http.Handler) http.Handler {
+	if mode == compressOff {
+		return handler
+	}
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet && request.Method != http.MethodHead {
+			handler.ServeHTTP(response, request)
+			return
+		}
+
+		accepted := acceptedEncodings(request.Header.Get("Accept-Encoding"))
+		for _, variant := range precompressedVariants {
+			if mode != compressAuto && mode != variant.encoding {
+				continue
+			}
+			if !accepted[variant.encoding] {
+				continue
+			}
+
+			file, err := store.Open(request.URL.Path + variant.ext)
+			if err != nil {
+				continue
+			}
+
+			info, err := file.Stat()
+			if err != nil {
+				file.Close()
+				continue
+			}
+
+			if contentType := mime.TypeByExtension(filepath.Ext(request.URL.Path)); contentType != "" {
+				response.Header().Set("Content-Type", contentType)
+			}
+			response.Header().Set("Content-Encoding", variant.encoding)
+			response.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+			response.Header().Add("Vary", "Accept-Encoding")
+			response.WriteHeader(http.StatusOK)
+			if request.Method != http.MethodHead {
+				io.Copy(response, file)
+			}
+			file.Close()
+			return
+		}
+
+		handler.ServeHTTP(response, request)
+	})
+}
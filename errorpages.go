@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// errorPageWriter buffers the status code of a response long enough to
+// check whether the document root has a matching error page (a
+// "<status>.html" file, or a generic "error.html"). If one exists, it is
+// streamed instead of the handler's own body, the way gitlab-workhorse's
+// staticpages.ErrorPages serves custom error pages.
+type errorPageWriter struct {
+	http.ResponseWriter
+	request      *http.Request
+	store        Store
+	wroteHeader  bool
+	intercepting bool
+}
+
+func errorPageCandidates(status int) []string {
+	return []string{
+		fmt.Sprintf("/%d.html", status),
+		"/error.html",
+	}
+}
+
+func (w *errorPageWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if status < http.StatusBadRequest {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	for _, name := range errorPageCandidates(status) {
+		file, err := w.store.Open(name)
+		if err != nil {
+			continue
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			continue
+		}
+
+		w.intercepting = true
+		w.ResponseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.ResponseWriter.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		w.ResponseWriter.WriteHeader(status)
+		if w.request.Method != http.MethodHead {
+			io.Copy(w.ResponseWriter, file)
+		}
+		file.Close()
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorPageWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.intercepting {
+		// the error page has already been written in WriteHeader; drop the
+		// handler's own body
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// withErrorPages intercepts responses with a status >= 400 and, if the
+// document root has a matching error page, serves that instead of Go's
+// default plain-text error body.
+func withErrorPages(store Store, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		wrapped := &errorPageWriter{ResponseWriter: response, request: request, store: store}
+		handler.ServeHTTP(wrapped, request)
+		if !wrapped.wroteHeader {
+			wrapped.WriteHeader(http.StatusOK)
+		}
+	})
+}
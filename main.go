@@ -2,17 +2,19 @@ package main
 
 import (
 	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/rs/cors"
@@ -36,14 +38,19 @@ func main() {
 }
 
 type Serve struct {
-	Port          int              `help:"Listen on this port." default:"4000"`
-	Dir           string           `help:"Serve files from this directory." arg:"" type:"existingdir"`
-	Prefix        string           `help:"Prefix all URL paths with this value." default:"/"`
-	Cors          bool             `help:"Include CORS support (on by default)." default:"true" negatable:""`
-	Dot           bool             `help:"Serve dot files (files prefixed with a '.')." default:"false"`
-	ExplicitIndex bool             `help:"Only serve index.html files if URL path includes it." default:"false"`
-	Spa           bool             `help:"Serve the index.html file for all unknown paths." default:"false"`
-	Version       kong.VersionFlag `help:"Print the version and exit."`
+	Port            int              `help:"Listen on this port." default:"4000"`
+	Dir             string           `help:"Serve files from this directory, or from a *.zip/*.tar/*.tar.gz archive." arg:"" type:"path"`
+	Prefix          string           `help:"Prefix all URL paths with this value." default:"/"`
+	Cors            bool             `help:"Include CORS support (on by default)." default:"true" negatable:""`
+	Dot             bool             `help:"Serve dot files (files prefixed with a '.')." default:"false"`
+	ExplicitIndex   bool             `help:"Only serve index.html files if URL path includes it." default:"false"`
+	Spa             bool             `help:"Serve the index.html file for all unknown paths." default:"false"`
+	Json            bool             `help:"Always respond to directory listings with JSON, regardless of the Accept header." default:"false"`
+	NoBrowse        bool             `help:"Disable the generated directory index. Directories without an index.html return 403." default:"false"`
+	Compress        string           `help:"Compress responses negotiated from Accept-Encoding." enum:"auto,gzip,br,zstd,off" default:"auto"`
+	CompressMinSize int              `help:"Minimum response size in bytes before compressing." default:"1024"`
+	ErrorPages      bool             `help:"Serve 404.html/500.html/error.html from the document root for error responses." default:"false"`
+	Version         kong.VersionFlag `help:"Print the version and exit."`
 }
 
 func normalizePrefix(base string, prefix string) (string, error) {
@@ -68,25 +75,39 @@ func (s *Serve) Run() error {
 	}
 	s.Prefix = prefix
 
-	handler := s.handler()
+	handler, closeStore, err := s.handler()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
 	fmt.Printf("Serving %s on %s%s\n", s.Dir, base, s.Prefix)
 	return http.ListenAndServe(fmt.Sprintf(":%d", s.Port), handler)
 }
 
-func (s *Serve) handler() http.Handler {
-	mux := http.NewServeMux()
+func (s *Serve) handler() (http.Handler, func() error, error) {
+	store, closeStore, err := openStore(s.Dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("trouble opening %s: %w", s.Dir, err)
+	}
 
-	dir := http.Dir(s.Dir)
-	mux.Handle(s.Prefix, http.StripPrefix(s.Prefix, http.FileServer(dir)))
+	mux := http.NewServeMux()
+	fileServer := withPrecompressed(store, s.Compress, http.FileServer(http.FS(store)))
+	mux.Handle(s.Prefix, stripPrefix(s.Prefix, fileServer))
 
-	handler := withIndex(string(dir), s.Prefix, s.Dot, s.ExplicitIndex, s.Spa, http.Handler(mux))
+	handler := withIndex(store, filepath.Base(s.Dir), s.Prefix, s.Dot, s.ExplicitIndex, s.Spa, s.Json, s.NoBrowse, http.Handler(mux))
 	if !s.Dot {
 		handler = excludeDot(handler)
 	}
+	handler = rejectUnsafePath(handler)
 	if s.Cors {
 		handler = cors.Default().Handler(handler)
 	}
-	return handler
+	if s.ErrorPages {
+		handler = withErrorPages(store, handler)
+	}
+	handler = withCompression(s.Compress, s.CompressMinSize, handler)
+	return handler, closeStore, nil
 }
 
 func excludeDot(handler http.Handler) http.Handler {
@@ -104,15 +125,29 @@ func excludeDot(handler http.Handler) http.Handler {
 }
 
 type IndexData struct {
-	Dir     string
-	Parents []*Entry
-	Entries []*Entry
+	Dir       string
+	Parents   []*Entry
+	Entries   []*Entry
+	SortField string
+	SortOrder string
 }
 
 type Entry struct {
-	Name string
-	Path string
-	Type string
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Type     string `json:"type"`
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+}
+
+// IndexListing is the JSON representation of a directory listing, returned
+// when a request's Accept header (or the --json flag) asks for it.
+type IndexListing struct {
+	Path        string   `json:"path"`
+	Parents     []*Entry `json:"parents"`
+	Entries     []*Entry `json:"entries"`
+	FileCount   int      `json:"fileCount"`
+	FolderCount int      `json:"folderCount"`
 }
 
 const (
@@ -120,27 +155,166 @@ const (
 	folderType = "folder"
 )
 
+const (
+	formatHtml = "html"
+	formatJson = "json"
+	formatText = "text"
+)
+
+// negotiateFormat picks a response format for a directory listing from the
+// request's Accept header, taking the first entry it recognizes in the
+// order the client listed them. Unrecognized or missing Accept headers
+// fall back to the HTML listing.
+func negotiateFormat(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		switch mediaType {
+		case "application/json":
+			return formatJson
+		case "text/plain":
+			return formatText
+		case "text/html", "*/*", "":
+			return formatHtml
+		}
+	}
+	return formatHtml
+}
+
+const sortCookieName = "serve-sort"
+
+// resolveSort reads the sort/order query parameters, falling back to the
+// sortCookieName cookie, then to name-ascending. The resolved values are
+// always written back to the cookie so later requests (including plain
+// directory navigation without query parameters) keep the same order, the
+// way Caddy's browse middleware does.
+func resolveSort(request *http.Request, response http.ResponseWriter, prefix string) (string, string) {
+	field := request.URL.Query().Get("sort")
+	order := request.URL.Query().Get("order")
+
+	if field == "" || order == "" {
+		if cookie, err := request.Cookie(sortCookieName); err == nil {
+			parts := strings.SplitN(cookie.Value, "-", 2)
+			if len(parts) == 2 {
+				if field == "" {
+					field = parts[0]
+				}
+				if order == "" {
+					order = parts[1]
+				}
+			}
+		}
+	}
+
+	switch field {
+	case "size", "time":
+	default:
+		field = "name"
+	}
+	if order != "desc" {
+		order = "asc"
+	}
+
+	http.SetCookie(response, &http.Cookie{
+		Name:  sortCookieName,
+		Value: field + "-" + order,
+		Path:  prefix,
+	})
+
+	return field, order
+}
+
+// sortEntries orders entries with folders first, then by the given field
+// (name, size, or time), breaking ties by name.
+func sortEntries(entries []*Entry, field string, order string) {
+	sort.Slice(entries, func(i int, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Type == folderType && b.Type != folderType {
+			return true
+		}
+		if b.Type == folderType && a.Type != folderType {
+			return false
+		}
+
+		less := a.Name < b.Name
+		switch field {
+		case "size":
+			if a.Size != b.Size {
+				less = a.Size < b.Size
+			}
+		case "time":
+			if a.Modified != b.Modified {
+				less = a.Modified < b.Modified
+			}
+		}
+
+		if order == "desc" {
+			return !less && a.Name != b.Name
+		}
+		return less
+	})
+}
+
+// humanizeSize renders a byte count the way "ls -h" does, e.g. "1.2 KB".
+func humanizeSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// humanizeTime renders an RFC3339 timestamp as a relative duration, e.g.
+// "5m ago". Unparseable input is returned unchanged.
+func humanizeTime(modified string) string {
+	t, err := time.Parse(time.RFC3339, modified)
+	if err != nil {
+		return modified
+	}
+	switch d := time.Since(t); {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
+
+var templateFuncs = template.FuncMap{
+	"humanizeSize": humanizeSize,
+	"humanizeTime": humanizeTime,
+}
+
 //go:embed index.html
 var indexHtml string
 
-func withIndex(dir string, prefix string, dot bool, explicitIndex bool, spa bool, handler http.Handler) http.Handler {
-	indexTemplate := template.Must(template.New("index").Parse(indexHtml))
-	base := filepath.Base(dir)
+func withIndex(store Store, base string, prefix string, dot bool, explicitIndex bool, spa bool, forceJson bool, noBrowse bool, handler http.Handler) http.Handler {
+	indexTemplate := template.Must(template.New("index").Funcs(templateFuncs).Parse(indexHtml))
+	escaped := escapedPrefix(prefix)
 	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
-		if !strings.HasPrefix(request.URL.Path, prefix) {
+		if !strings.HasPrefix(request.URL.EscapedPath(), escaped) {
 			http.NotFound(response, request)
 			return
 		}
 
-		urlPath := "/" + strings.TrimPrefix(request.URL.Path, prefix)
+		urlPath, err := safePath(strings.TrimPrefix(request.URL.Path, prefix))
+		if err != nil {
+			http.Error(response, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
 
 		if strings.HasSuffix(urlPath, "/index.html") && explicitIndex {
 			// we need to avoid the built-in redirect
-			indexPath := filepath.Join(dir, urlPath)
-
-			indexFile, err := os.Open(indexPath)
+			indexFile, err := store.Open(urlPath)
 			if err != nil {
-				if errors.Is(err, os.ErrNotExist) {
+				if errors.Is(err, fs.ErrNotExist) {
 					http.NotFound(response, request)
 					return
 				}
@@ -152,16 +326,20 @@ func withIndex(dir string, prefix string, dot bool, explicitIndex bool, spa bool
 			response.Header().Set("Content-Type", "text/html; charset=utf-8")
 			response.WriteHeader(http.StatusOK)
 			if _, err := io.Copy(response, indexFile); err != nil {
-				fmt.Printf("failed to write %s: %s", indexPath, err)
+				fmt.Printf("failed to write %s: %s", urlPath, err)
 			}
 			return
 		}
 
 		if !strings.HasSuffix(urlPath, "/") {
 			if spa {
-				// if not found, serve dir/index.html
-				if _, err := os.Stat(path.Join(dir, urlPath)); errors.Is(err, os.ErrNotExist) {
-					http.ServeFile(response, request, path.Join(dir, "index.html"))
+				// if not found, serve prefix/index.html through the same
+				// file server so content type and range handling stay
+				// consistent with normal requests
+				if _, err := store.Open(urlPath); errors.Is(err, fs.ErrNotExist) {
+					spaRequest := request.Clone(request.Context())
+					spaRequest.URL.Path = path.Join(prefix, "index.html")
+					handler.ServeHTTP(response, spaRequest)
 					return
 				}
 			}
@@ -169,10 +347,9 @@ func withIndex(dir string, prefix string, dot bool, explicitIndex bool, spa bool
 			return
 		}
 
-		dirPath := filepath.Join(dir, urlPath)
-		list, dirErr := os.ReadDir(dirPath)
+		list, dirErr := store.ReadDir(urlPath)
 		if dirErr != nil {
-			if errors.Is(dirErr, os.ErrNotExist) {
+			if errors.Is(dirErr, fs.ErrNotExist) {
 				http.NotFound(response, request)
 				return
 			}
@@ -180,7 +357,16 @@ func withIndex(dir string, prefix string, dot bool, explicitIndex bool, spa bool
 			return
 		}
 
+		format := formatHtml
+		if forceJson {
+			format = formatJson
+		} else {
+			format = negotiateFormat(request.Header.Get("Accept"))
+		}
+
 		hasIndex := false
+		fileCount := 0
+		folderCount := 0
 		entries := []*Entry{}
 		for _, item := range list {
 			name := item.Name()
@@ -191,14 +377,20 @@ func withIndex(dir string, prefix string, dot bool, explicitIndex bool, spa bool
 				Name: name,
 				Path: path.Join(prefix, urlPath, name),
 			}
+			if info, infoErr := item.Info(); infoErr == nil {
+				entry.Size = info.Size()
+				entry.Modified = info.ModTime().UTC().Format(time.RFC3339)
+			}
 			if item.IsDir() {
 				entry.Type = folderType
 				entry.Path = entry.Path + "/"
+				folderCount++
 			} else {
 				entry.Type = fileType
+				fileCount++
 				if name == "index.html" {
 					hasIndex = true
-					if !explicitIndex {
+					if !explicitIndex && format == formatHtml {
 						break
 					}
 				}
@@ -206,32 +398,19 @@ func withIndex(dir string, prefix string, dot bool, explicitIndex bool, spa bool
 			entries = append(entries, entry)
 		}
 
-		if hasIndex && !explicitIndex {
+		if hasIndex && !explicitIndex && format == formatHtml {
 			handler.ServeHTTP(response, request)
 			return
 		}
 
-		sort.Slice(entries, func(i int, j int) bool {
-			iEntry := entries[i]
-			jEntry := entries[j]
-			if iEntry.Type == folderType && jEntry.Type != folderType {
-				return true
-			}
-			if jEntry.Type == folderType && iEntry.Type != folderType {
-				return false
-			}
-			return iEntry.Name < jEntry.Name
-		})
-
-		if urlPath != "/" {
-			parentEntry := &Entry{
-				Name: "..",
-				Path: path.Join(prefix, urlPath, ".."),
-				Type: folderType,
-			}
-			entries = append([]*Entry{parentEntry}, entries...)
+		if noBrowse && !hasIndex {
+			http.Error(response, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
 		}
 
+		sortField, sortOrder := resolveSort(request, response, prefix)
+		sortEntries(entries, sortField, sortOrder)
+
 		parentParts := strings.Split(urlPath, "/")
 		parentParts = parentParts[:len(parentParts)-1]
 		parentEntries := make([]*Entry, len(parentParts))
@@ -247,10 +426,47 @@ func withIndex(dir string, prefix string, dot bool, explicitIndex bool, spa bool
 			parentEntries[i] = entry
 		}
 
+		dirPath := filepath.Join(base, urlPath)
+
+		switch format {
+		case formatJson:
+			response.Header().Set("Content-Type", "application/json; charset=utf-8")
+			response.WriteHeader(http.StatusOK)
+			listing := &IndexListing{
+				Path:        dirPath,
+				Parents:     parentEntries,
+				Entries:     entries,
+				FileCount:   fileCount,
+				FolderCount: folderCount,
+			}
+			if err := json.NewEncoder(response).Encode(listing); err != nil {
+				fmt.Printf("trouble encoding listing: %s\n", err)
+			}
+			return
+		case formatText:
+			response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			response.WriteHeader(http.StatusOK)
+			for _, entry := range entries {
+				fmt.Fprintln(response, entry.Name)
+			}
+			return
+		}
+
+		if urlPath != "/" {
+			parentEntry := &Entry{
+				Name: "..",
+				Path: path.Join(prefix, urlPath, ".."),
+				Type: folderType,
+			}
+			entries = append([]*Entry{parentEntry}, entries...)
+		}
+
 		data := &IndexData{
-			Dir:     filepath.Join(base, urlPath),
-			Entries: entries,
-			Parents: parentEntries,
+			Dir:       dirPath,
+			Entries:   entries,
+			Parents:   parentEntries,
+			SortField: sortField,
+			SortOrder: sortOrder,
 		}
 
 		response.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -67,6 +75,62 @@ func TestNormalizePrefix(t *testing.T) {
 	}
 }
 
+func TestSafePath(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "plain file",
+			input: "file.txt",
+			want:  "/file.txt",
+		},
+		{
+			name:  "nested directory",
+			input: "sub/file.txt",
+			want:  "/sub/file.txt",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := safePath(c.input)
+			require.NoError(t, err)
+			assert.Equal(t, c.want, got)
+		})
+	}
+
+	rejections := []struct {
+		name  string
+		input string
+	}{
+		{
+			name:  "dot dot segment",
+			input: "../secret.txt",
+		},
+		{
+			name:  "embedded dot dot segment",
+			input: "foo/../../secret.txt",
+		},
+		{
+			name:  "embedded null byte",
+			input: "foo/\x00bar",
+		},
+		{
+			name:  "backslash",
+			input: "foo\\..\\secret.txt",
+		},
+	}
+
+	for _, c := range rejections {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := safePath(c.input)
+			assert.Error(t, err)
+		})
+	}
+}
+
 func mustNormalizePrefix(prefix string) string {
 	p, err := normalizePrefix(base, prefix)
 	if err != nil {
@@ -95,7 +159,8 @@ func TestServeIndex(t *testing.T) {
 		Prefix: mustNormalizePrefix("/"),
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", "/", nil)
 	recorder := httptest.NewRecorder()
@@ -114,6 +179,94 @@ func TestServeIndex(t *testing.T) {
 	assert.Contains(t, string(body), title)
 }
 
+func TestServeIndexJSON(t *testing.T) {
+	testDir := "root"
+
+	s := &Serve{
+		Dir:    fmt.Sprintf("testdata/%s", testDir),
+		Prefix: mustNormalizePrefix("/"),
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Accept", "application/json")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "application/json; charset=utf-8", response.Header.Get("Content-Type"))
+
+	var listing IndexListing
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&listing))
+
+	assert.Equal(t, testDir, listing.Path)
+	assert.True(t, listing.FolderCount >= 1)
+	assert.True(t, listing.FileCount >= 2)
+
+	var names []string
+	for _, entry := range listing.Entries {
+		names = append(names, entry.Name)
+	}
+	assert.Contains(t, names, "file.txt")
+	assert.Contains(t, names, "sub")
+}
+
+func TestServeIndexJSONFlag(t *testing.T) {
+	testDir := "root"
+
+	s := &Serve{
+		Dir:    fmt.Sprintf("testdata/%s", testDir),
+		Prefix: mustNormalizePrefix("/"),
+		Json:   true,
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "application/json; charset=utf-8", response.Header.Get("Content-Type"))
+}
+
+func TestServeIndexPlainText(t *testing.T) {
+	testDir := "root"
+
+	s := &Serve{
+		Dir:    fmt.Sprintf("testdata/%s", testDir),
+		Prefix: mustNormalizePrefix("/"),
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Accept", "text/plain")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "text/plain; charset=utf-8", response.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	assert.Contains(t, string(body), "file.txt\n")
+}
+
 func TestServeIndexWithPrefix(t *testing.T) {
 	testDir := "root"
 	prefix := mustNormalizePrefix("/foo/bar/")
@@ -123,7 +276,8 @@ func TestServeIndexWithPrefix(t *testing.T) {
 		Prefix: prefix,
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", prefix, nil)
 	recorder := httptest.NewRecorder()
@@ -151,7 +305,8 @@ func TestServeWithPrefixNotFound(t *testing.T) {
 		Prefix: prefix,
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", "/", nil)
 	recorder := httptest.NewRecorder()
@@ -172,7 +327,8 @@ func TestServeFile(t *testing.T) {
 		Prefix: mustNormalizePrefix("/"),
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", fmt.Sprintf("/%s", filePath), nil)
 	recorder := httptest.NewRecorder()
@@ -200,7 +356,8 @@ func TestServeFileWithPrefix(t *testing.T) {
 		Prefix: prefix,
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", mustJoinPath(s.Prefix, filePath), nil)
 	recorder := httptest.NewRecorder()
@@ -228,7 +385,8 @@ func TestServeFileWithPrefixNotFound(t *testing.T) {
 		Prefix: prefix,
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", "/"+filePath, nil)
 	recorder := httptest.NewRecorder()
@@ -249,7 +407,8 @@ func TestServeCSS(t *testing.T) {
 		Prefix: mustNormalizePrefix("/"),
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", fmt.Sprintf("/%s", filePath), nil)
 	recorder := httptest.NewRecorder()
@@ -271,7 +430,8 @@ func TestServeSubIndex(t *testing.T) {
 		Prefix: mustNormalizePrefix("/"),
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", fmt.Sprintf("/%s/", dirPath), nil)
 	recorder := httptest.NewRecorder()
@@ -299,7 +459,8 @@ func TestServeSubIndexRedirect(t *testing.T) {
 		Prefix: mustNormalizePrefix("/"),
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", fmt.Sprintf("/%s/index.html", dirPath), nil)
 	recorder := httptest.NewRecorder()
@@ -321,7 +482,8 @@ func TestServeSubDirRedirect(t *testing.T) {
 		Prefix: mustNormalizePrefix("/"),
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", fmt.Sprintf("/%s", dirPath), nil)
 	recorder := httptest.NewRecorder()
@@ -343,7 +505,8 @@ func TestServeSubFile(t *testing.T) {
 		Prefix: mustNormalizePrefix("/"),
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", fmt.Sprintf("/%s", filePath), nil)
 	recorder := httptest.NewRecorder()
@@ -371,7 +534,8 @@ func TestServeSubFilePrefix(t *testing.T) {
 		Prefix: prefix,
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", mustJoinPath(prefix, filePath), nil)
 	recorder := httptest.NewRecorder()
@@ -397,7 +561,8 @@ func TestServeCustomIndex(t *testing.T) {
 		Prefix: mustNormalizePrefix("/"),
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", "/", nil)
 	recorder := httptest.NewRecorder()
@@ -424,7 +589,8 @@ func TestServeCustomSubIndex(t *testing.T) {
 		Prefix: mustNormalizePrefix("/"),
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", fmt.Sprintf("/%s/", dirPath), nil)
 	recorder := httptest.NewRecorder()
@@ -451,7 +617,8 @@ func TestServeExplicitIndexNotInPath(t *testing.T) {
 		ExplicitIndex: true,
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", "/", nil)
 	recorder := httptest.NewRecorder()
@@ -479,7 +646,8 @@ func TestServeExplicitIndexInPath(t *testing.T) {
 		ExplicitIndex: true,
 	}
 
-	handler := s.handler()
+	handler, _, err := s.handler()
+	require.NoError(t, err)
 
 	request := httptest.NewRequest("GET", "/index.html", nil)
 	recorder := httptest.NewRecorder()
@@ -496,3 +664,567 @@ func TestServeExplicitIndexInPath(t *testing.T) {
 
 	assert.Equal(t, "root-with-index/index.html\n", string(body))
 }
+
+func TestServeIndexSortSetsCookie(t *testing.T) {
+	testDir := "root"
+
+	s := &Serve{
+		Dir:    fmt.Sprintf("testdata/%s", testDir),
+		Prefix: mustNormalizePrefix("/"),
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/?sort=size&order=desc", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	cookies := response.Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, sortCookieName, cookies[0].Name)
+	assert.Equal(t, "size-desc", cookies[0].Value)
+}
+
+func TestServeIndexNoBrowse(t *testing.T) {
+	testDir := "root"
+
+	s := &Serve{
+		Dir:      fmt.Sprintf("testdata/%s", testDir),
+		Prefix:   mustNormalizePrefix("/"),
+		NoBrowse: true,
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+}
+
+func TestServeIndexNoBrowseWithIndex(t *testing.T) {
+	testDir := "root-with-index"
+
+	s := &Serve{
+		Dir:      fmt.Sprintf("testdata/%s", testDir),
+		Prefix:   mustNormalizePrefix("/"),
+		NoBrowse: true,
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "root-with-index/index.html\n", string(body))
+}
+
+func TestAcceptedEncodings(t *testing.T) {
+	cases := []struct {
+		name   string
+		header string
+		want   map[string]bool
+	}{
+		{
+			name:   "single coding",
+			header: "gzip",
+			want:   map[string]bool{"gzip": true},
+		},
+		{
+			name:   "weighted codings are still accepted",
+			header: "gzip;q=0.8, br;q=0.9",
+			want:   map[string]bool{"gzip": true, "br": true},
+		},
+		{
+			name:   "zero quality is excluded",
+			header: "gzip;q=0, br",
+			want:   map[string]bool{"br": true},
+		},
+		{
+			name:   "zero quality with trailing digits is excluded",
+			header: "gzip;q=0.0",
+			want:   map[string]bool{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, acceptedEncodings(c.header))
+		})
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		name           string
+		acceptEncoding string
+		mode           string
+		want           string
+	}{
+		{
+			name:           "auto prefers brotli",
+			acceptEncoding: "gzip, br",
+			mode:           compressAuto,
+			want:           compressBr,
+		},
+		{
+			name:           "explicit mode ignored if not accepted",
+			acceptEncoding: "gzip",
+			mode:           compressBr,
+			want:           "",
+		},
+		{
+			name:           "weighted header doesn't disable compression",
+			acceptEncoding: "gzip;q=0.8, br;q=0.9",
+			mode:           compressAuto,
+			want:           compressBr,
+		},
+		{
+			name:           "explicit zero quality disables that coding",
+			acceptEncoding: "gzip;q=0",
+			mode:           compressGzip,
+			want:           "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, negotiateEncoding(c.acceptEncoding, c.mode))
+		})
+	}
+}
+
+func TestServeCompressedGzip(t *testing.T) {
+	testDir := "root"
+
+	s := &Serve{
+		Dir:             fmt.Sprintf("testdata/%s", testDir),
+		Prefix:          mustNormalizePrefix("/"),
+		Compress:        "auto",
+		CompressMinSize: 1,
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/style.css", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "gzip", response.Header.Get("Content-Encoding"))
+
+	reader, err := gzip.NewReader(response.Body)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "body { color: red; }\n", string(body))
+}
+
+func TestServeCompressedBelowMinSize(t *testing.T) {
+	testDir := "root"
+
+	s := &Serve{
+		Dir:             fmt.Sprintf("testdata/%s", testDir),
+		Prefix:          mustNormalizePrefix("/"),
+		Compress:        "auto",
+		CompressMinSize: 1 << 20,
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/style.css", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "", response.Header.Get("Content-Encoding"))
+}
+
+func TestServeCompressedSkipsRangeRequests(t *testing.T) {
+	testDir := "root"
+
+	s := &Serve{
+		Dir:             fmt.Sprintf("testdata/%s", testDir),
+		Prefix:          mustNormalizePrefix("/"),
+		Compress:        "auto",
+		CompressMinSize: 1,
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/style.css", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	request.Header.Set("Range", "bytes=0-4")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusPartialContent, response.StatusCode)
+	assert.Equal(t, "", response.Header.Get("Content-Encoding"))
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "body { color: red; }\n"[:5], string(body))
+}
+
+func TestServePrecompressedSidecar(t *testing.T) {
+	testDir := "root"
+
+	s := &Serve{
+		Dir:      fmt.Sprintf("testdata/%s", testDir),
+		Prefix:   mustNormalizePrefix("/"),
+		Compress: "auto",
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/compressed/app.js", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "gzip", response.Header.Get("Content-Encoding"))
+	assert.Equal(t, mime.TypeByExtension(".js"), response.Header.Get("Content-Type"))
+
+	reader, err := gzip.NewReader(response.Body)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "gz-sidecar-body\n", string(body))
+}
+
+func TestServeErrorPagesNotFound(t *testing.T) {
+	testDir := "root-with-errors"
+
+	s := &Serve{
+		Dir:        fmt.Sprintf("testdata/%s", testDir),
+		Prefix:     mustNormalizePrefix("/"),
+		ErrorPages: true,
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/missing.txt", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", response.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "Custom 404 page")
+}
+
+func TestServeErrorPagesGenericFallback(t *testing.T) {
+	testDir := "root-with-errors"
+
+	s := &Serve{
+		Dir:        fmt.Sprintf("testdata/%s", testDir),
+		Prefix:     mustNormalizePrefix("/"),
+		NoBrowse:   true,
+		ErrorPages: true,
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", response.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "Generic error page")
+}
+
+func TestServeErrorPagesDisabled(t *testing.T) {
+	testDir := "root-with-errors"
+
+	s := &Serve{
+		Dir:    fmt.Sprintf("testdata/%s", testDir),
+		Prefix: mustNormalizePrefix("/"),
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/missing.txt", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "Custom 404 page")
+}
+
+func TestServeRejectsEncodedTraversal(t *testing.T) {
+	testDir := "root"
+
+	s := &Serve{
+		Dir:    fmt.Sprintf("testdata/%s", testDir),
+		Prefix: mustNormalizePrefix("/"),
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/..%2fetc%2fpasswd", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestServeRejectsMixedCaseEncodedTraversal(t *testing.T) {
+	testDir := "root"
+	prefix := mustNormalizePrefix("/foo/")
+
+	s := &Serve{
+		Dir:    fmt.Sprintf("testdata/%s", testDir),
+		Prefix: prefix,
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/foo/%2e%2E/secret", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestServeRejectsPrefixBypassAttempt(t *testing.T) {
+	testDir := "root"
+	prefix := mustNormalizePrefix("/foo/")
+
+	s := &Serve{
+		Dir:    fmt.Sprintf("testdata/%s", testDir),
+		Prefix: prefix,
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/foo/..%2fbar", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestServeStaticFileRejectsEncodedTraversal(t *testing.T) {
+	testDir := "root"
+	prefix := mustNormalizePrefix("/foo/")
+
+	s := &Serve{
+		Dir:    fmt.Sprintf("testdata/%s", testDir),
+		Prefix: prefix,
+	}
+
+	handler, _, err := s.handler()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest("GET", "/foo/..%2fmain.go", nil)
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+const archiveFileContents = "hello zip and tar archives\n"
+
+func writeZipFixture(t *testing.T) string {
+	t.Helper()
+
+	archivePath := filepath.Join(t.TempDir(), "root.zip")
+	file, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+
+	// hello.txt is stored without compression so reads exercise the
+	// io.SectionReader path in zipStore.Open.
+	entry, err := writer.CreateHeader(&zip.FileHeader{Name: "hello.txt", Method: zip.Store})
+	require.NoError(t, err)
+	_, err = entry.Write([]byte(archiveFileContents))
+	require.NoError(t, err)
+
+	// sub/nested.txt uses the writer's default (deflate) compression, so
+	// reads exercise the buffered path.
+	entry, err = writer.Create("sub/nested.txt")
+	require.NoError(t, err)
+	_, err = entry.Write([]byte(archiveFileContents))
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Close())
+	return archivePath
+}
+
+func writeTarFixture(t *testing.T, gzipped bool) string {
+	t.Helper()
+
+	name := "root.tar"
+	if gzipped {
+		name = "root.tar.gz"
+	}
+	archivePath := filepath.Join(t.TempDir(), name)
+	file, err := os.Create(archivePath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var writer io.Writer = file
+	var gzipWriter *gzip.Writer
+	if gzipped {
+		gzipWriter = gzip.NewWriter(file)
+		writer = gzipWriter
+	}
+
+	tarWriter := tar.NewWriter(writer)
+	for _, name := range []string{"hello.txt", "sub/nested.txt"} {
+		require.NoError(t, tarWriter.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(len(archiveFileContents)),
+			ModTime: time.Now(),
+		}))
+		_, err := tarWriter.Write([]byte(archiveFileContents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+	if gzipped {
+		require.NoError(t, gzipWriter.Close())
+	}
+
+	return archivePath
+}
+
+func testServeArchive(t *testing.T, archivePath string) {
+	t.Helper()
+
+	s := &Serve{
+		Dir:    archivePath,
+		Prefix: mustNormalizePrefix("/"),
+		Json:   true,
+	}
+
+	handler, closeStore, err := s.handler()
+	require.NoError(t, err)
+	defer closeStore()
+
+	request := httptest.NewRequest("GET", "/hello.txt", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	response := recorder.Result()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, archiveFileContents, string(body))
+
+	request = httptest.NewRequest("GET", "/", nil)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	response = recorder.Result()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	var listing IndexListing
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&listing))
+	names := []string{}
+	for _, entry := range listing.Entries {
+		names = append(names, entry.Name)
+	}
+	assert.Contains(t, names, "hello.txt")
+	assert.Contains(t, names, "sub")
+
+	request = httptest.NewRequest("GET", "/hello.txt", nil)
+	request.Header.Set("Range", "bytes=0-4")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	response = recorder.Result()
+	require.Equal(t, http.StatusPartialContent, response.StatusCode)
+	body, err = io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, archiveFileContents[:5], string(body))
+}
+
+func TestServeZipArchive(t *testing.T) {
+	testServeArchive(t, writeZipFixture(t))
+}
+
+func TestServeTarArchive(t *testing.T) {
+	testServeArchive(t, writeTarFixture(t, false))
+}
+
+func TestServeTarGzArchive(t *testing.T) {
+	testServeArchive(t, writeTarFixture(t, true))
+}
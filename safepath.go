@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// safePath validates a path trimmed from a request URL before it reaches
+// the Store, rejecting the tricks GitLab Workhorse's path sanitization
+// guards against: embedded NULs, backslashes (a traversal separator on
+// Windows), and ".." segments that would climb above the document root.
+// Percent-encoded separators like %2e%2e or %2f are already decoded into
+// their literal form by the time net/http populates request.URL.Path, so
+// checking for literal ".." segments here also catches those.
+func safePath(name string) (string, error) {
+	if strings.IndexByte(name, 0) >= 0 {
+		return "", fmt.Errorf("path contains a null byte")
+	}
+	if strings.ContainsRune(name, '\\') {
+		return "", fmt.Errorf("path contains a backslash")
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("path escapes the root")
+		}
+	}
+	return "/" + name, nil
+}
+
+// escapedPrefix renders a URL path prefix the same way
+// request.URL.EscapedPath() renders a request, so the two can be compared
+// without one side being silently decoded out from under the other.
+func escapedPrefix(prefix string) string {
+	return (&url.URL{Path: prefix}).EscapedPath()
+}
+
+// rejectUnsafePath runs safePath against the full request path ahead of
+// everything else in the handler chain (including excludeDot, which would
+// otherwise treat a ".." segment as just another dot-file and 404 it),
+// so a clearly malicious path is rejected with 400 instead of blending in
+// with an ordinary not-found response.
+func rejectUnsafePath(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if _, err := safePath(strings.TrimPrefix(request.URL.Path, "/")); err != nil {
+			http.Error(response, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		handler.ServeHTTP(response, request)
+	})
+}
+
+// stripPrefix behaves like http.StripPrefix, but matches the prefix
+// against the request's escaped path, rather than its already-decoded
+// Path, so a percent-encoded separator can't be used to sneak a path past
+// the prefix check. Paths that fail safePath are rejected with 400 rather
+// than left to reach the filesystem layer.
+func stripPrefix(prefix string, handler http.Handler) http.Handler {
+	escaped := escapedPrefix(prefix)
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if !strings.HasPrefix(request.URL.EscapedPath(), escaped) {
+			http.NotFound(response, request)
+			return
+		}
+
+		trimmed, err := safePath(strings.TrimPrefix(request.URL.Path, prefix))
+		if err != nil {
+			http.Error(response, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		clone := request.Clone(request.Context())
+		clone.URL.Path = trimmed
+		clone.URL.RawPath = ""
+		handler.ServeHTTP(response, clone)
+	})
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Store is the minimal filesystem interface withIndex and the file server
+// need.  It is satisfied by fs.FS implementations that also support
+// directory listing, which lets a plain directory, a zip archive, or a
+// tar archive all be served the same way.
+type Store interface {
+	Open(name string) (fs.File, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+}
+
+// storeFSPath converts a "/"-rooted URL-style path (as used throughout
+// withIndex) into the rooted, slash-separated path that fs.FS implementations
+// expect (no leading slash, "." for the root).
+func storeFSPath(name string) string {
+	trimmed := strings.Trim(name, "/")
+	if trimmed == "" {
+		return "."
+	}
+	return trimmed
+}
+
+// fileStore serves files from a directory on disk.
+type fileStore struct {
+	fsys fs.FS
+}
+
+func newFileStore(dir string) *fileStore {
+	return &fileStore{fsys: os.DirFS(dir)}
+}
+
+func (s *fileStore) Open(name string) (fs.File, error) {
+	return s.fsys.Open(storeFSPath(name))
+}
+
+func (s *fileStore) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(s.fsys, storeFSPath(name))
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}
+
+// zipStore serves files directly out of a zip archive without unpacking it
+// to disk. It keeps the archive's underlying file open for the life of the
+// server, so a Store (uncompressed) entry can be read with an
+// io.SectionReader straight off disk instead of being buffered in memory.
+type zipStore struct {
+	file    *os.File
+	reader  *zip.Reader
+	entries map[string]*zip.File
+}
+
+func newZipStore(archivePath string) (*zipStore, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("trouble opening zip archive: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("trouble reading zip archive: %w", err)
+	}
+
+	reader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("trouble opening zip archive: %w", err)
+	}
+
+	entries := make(map[string]*zip.File, len(reader.File))
+	for _, entry := range reader.File {
+		entries[entry.Name] = entry
+	}
+
+	return &zipStore{file: file, reader: reader, entries: entries}, nil
+}
+
+func (s *zipStore) Open(name string) (fs.File, error) {
+	fsPath := storeFSPath(name)
+	file, err := s.reader.Open(fsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		return file, nil
+	}
+	defer file.Close()
+
+	// http.FS requires a seekable file for content sniffing and Range
+	// requests, but zip.File.Open only returns an io.Seeker for entries
+	// stored without compression. For those, read straight off the
+	// archive's underlying file with an io.SectionReader instead of
+	// copying the entry into memory. Compressed entries still need to be
+	// inflated up front, so buffer those the way tarRegularFile does for
+	// tar entries.
+	if entry := s.entries[fsPath]; entry != nil && entry.Method == zip.Store {
+		offset, err := entry.DataOffset()
+		if err != nil {
+			return nil, fmt.Errorf("trouble locating %s in archive: %w", name, err)
+		}
+		section := io.NewSectionReader(s.file, offset, int64(entry.UncompressedSize64))
+		return &zipRegularFile{info: info, ReadSeeker: section}, nil
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("trouble reading %s: %w", name, err)
+	}
+	return &zipRegularFile{info: info, ReadSeeker: bytes.NewReader(data)}, nil
+}
+
+func (s *zipStore) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(s.reader, storeFSPath(name))
+}
+
+func (s *zipStore) Close() error {
+	return s.file.Close()
+}
+
+// zipRegularFile wraps a zip entry's content in an io.Seeker, either a
+// section reader over the archive (for uncompressed entries) or a buffer
+// (for compressed ones), the way tarRegularFile does for tar entries.
+type zipRegularFile struct {
+	info fs.FileInfo
+	io.ReadSeeker
+}
+
+func (f *zipRegularFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *zipRegularFile) Close() error               { return nil }
+
+// tarStore serves files out of a tar (optionally gzip compressed) archive.
+// Tar archives don't support random access the way zip does, so the archive
+// is read once at startup and its entries are buffered in memory.
+type tarStore struct {
+	files map[string]*tarFile
+	dirs  map[string][]fs.DirEntry
+}
+
+type tarFile struct {
+	name    string
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (f *tarFile) Name() string       { return path.Base(f.name) }
+func (f *tarFile) Size() int64        { return int64(len(f.data)) }
+func (f *tarFile) Mode() fs.FileMode  { return f.mode }
+func (f *tarFile) ModTime() time.Time { return f.modTime }
+func (f *tarFile) IsDir() bool        { return f.mode.IsDir() }
+func (f *tarFile) Sys() any           { return nil }
+
+func (f *tarFile) Info() (fs.FileInfo, error) { return f, nil }
+func (f *tarFile) Type() fs.FileMode          { return f.mode.Type() }
+
+func newTarStore(archivePath string) (*tarStore, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("trouble opening tar archive: %w", err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(archivePath, ".gz") || strings.HasSuffix(archivePath, ".tgz") {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("trouble reading gzip archive: %w", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	store := &tarStore{
+		files: map[string]*tarFile{},
+		dirs:  map[string][]fs.DirEntry{},
+	}
+	store.files["."] = &tarFile{name: ".", mode: fs.ModeDir}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("trouble reading tar entry: %w", err)
+		}
+
+		name := storeFSPath(path.Clean("/" + header.Name))
+		entry := &tarFile{
+			name:    name,
+			mode:    fs.FileMode(header.Mode),
+			modTime: header.ModTime,
+		}
+		if header.Typeflag == tar.TypeDir {
+			entry.mode |= fs.ModeDir
+		} else {
+			data, err := io.ReadAll(tarReader)
+			if err != nil {
+				return nil, fmt.Errorf("trouble reading %s: %w", header.Name, err)
+			}
+			entry.data = data
+		}
+		store.addEntry(entry)
+	}
+
+	return store, nil
+}
+
+// addEntry records the entry and makes sure every ancestor directory exists
+// and lists it, since tar archives don't always include explicit directory
+// entries for every parent.
+func (s *tarStore) addEntry(entry *tarFile) {
+	if existing, ok := s.files[entry.name]; ok && !existing.mode.IsDir() {
+		return
+	}
+	s.files[entry.name] = entry
+
+	name := entry.name
+	for name != "." {
+		parent := path.Dir(name)
+		if parent == name {
+			break
+		}
+		if _, ok := s.files[parent]; !ok {
+			s.files[parent] = &tarFile{name: parent, mode: fs.ModeDir}
+		}
+		s.addChild(parent, name)
+		name = parent
+	}
+}
+
+func (s *tarStore) addChild(parent string, child string) {
+	for _, existing := range s.dirs[parent] {
+		if existing.Name() == path.Base(child) {
+			return
+		}
+	}
+	s.dirs[parent] = append(s.dirs[parent], s.files[child])
+}
+
+func (s *tarStore) Open(name string) (fs.File, error) {
+	fsPath := storeFSPath(name)
+	entry, ok := s.files[fsPath]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.mode.IsDir() {
+		entries := append([]fs.DirEntry{}, s.dirs[fsPath]...)
+		sort.Slice(entries, func(i int, j int) bool { return entries[i].Name() < entries[j].Name() })
+		return &tarDirFile{tarFile: entry, entries: entries}, nil
+	}
+	return &tarRegularFile{tarFile: entry, Reader: bytes.NewReader(entry.data)}, nil
+}
+
+func (s *tarStore) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := s.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := file.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return dir.ReadDir(-1)
+}
+
+func (s *tarStore) Close() error {
+	return nil
+}
+
+type tarRegularFile struct {
+	*tarFile
+	*bytes.Reader
+}
+
+func (f *tarRegularFile) Stat() (fs.FileInfo, error) { return f.tarFile, nil }
+func (f *tarRegularFile) Close() error               { return nil }
+
+type tarDirFile struct {
+	*tarFile
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (f *tarDirFile) Stat() (fs.FileInfo, error) { return f.tarFile, nil }
+func (f *tarDirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+}
+func (f *tarDirFile) Close() error { return nil }
+
+func (f *tarDirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := f.entries[f.offset:]
+		f.offset = len(f.entries)
+		return entries, nil
+	}
+	if f.offset >= len(f.entries) {
+		return nil, io.EOF
+	}
+	end := f.offset + n
+	if end > len(f.entries) {
+		end = len(f.entries)
+	}
+	entries := f.entries[f.offset:end]
+	f.offset = end
+	return entries, nil
+}
+
+// openStore picks a Store implementation based on the Dir argument: a plain
+// directory is served from disk, while a *.zip or *.tar(.gz)/*.tgz archive
+// is served directly out of the archive. The returned close function should
+// be called when the server shuts down.
+func openStore(dir string) (Store, func() error, error) {
+	switch {
+	case strings.HasSuffix(dir, ".zip"):
+		store, err := newZipStore(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, store.Close, nil
+	case strings.HasSuffix(dir, ".tar"), strings.HasSuffix(dir, ".tar.gz"), strings.HasSuffix(dir, ".tgz"):
+		store, err := newTarStore(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		return store, store.Close, nil
+	default:
+		store := newFileStore(dir)
+		return store, store.Close, nil
+	}
+}